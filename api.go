@@ -8,14 +8,26 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/marcboeker/go-duckdb" // DuckDB driver for Go's database/sql
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// httpRequestDuration tracks Gin request latency per route and status code,
+// exposed on /metrics alongside the collector metrics in metrics.go.
+var httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name: "ais_http_request_duration_seconds",
+	Help: "HTTP request latency of the AIS API, by route and status code.",
+}, []string{"route", "method", "status"})
+
 var db *sql.DB
 
 // runAPI initializes the HTTP server with RESTful routes using Gin,
@@ -26,28 +38,54 @@ var db *sql.DB
 func runAPI(db *sql.DB, ctx context.Context) {
 	router := gin.Default()
 
-	// Global middleware to extract 'from'/'to' date parameters from the query string
-	router.Use(func(c *gin.Context) {
-		middlewareDateRange(c)
-	})
+	// Records per-route latency for every request, regardless of outcome.
+	router.Use(middlewareRequestMetrics)
 
 	// === API ROUTES ===
 
+	// Prometheus scrape endpoint. Outside the dateScoped group: it has
+	// nothing to do with Parquet files, and Prometheus doesn't send from/to.
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Reports progress of an in-flight --replay run, if any.
+	router.GET("/replay/status", getReplayStatus)
+
+	// Live subscription feeds. Outside dateScoped: these stream the buffer
+	// as it arrives and take their own mmsi/bbox/messageType/sampleHz
+	// filters instead of a from/to range.
+	router.GET("/stream/ws", streamWS)
+	router.GET("/stream/sse", streamSSE)
+
+	// Operator-triggered out-of-band compaction; see compact.go.
+	router.GET("/admin/compact", func(c *gin.Context) { compactNow(c, db) })
+
+	// dateScoped groups every route that operates over a from/to Parquet
+	// file selection, injected into the Gin context by middlewareDateRange.
+	dateScoped := router.Group("/", middlewareDateRange)
+
 	// Example: GET /ships/273450000?from=2023-09-01&to=2023-09-03
 	// Returns all AIS messages for a given MMSI over a date range
-	router.GET("/ships/:mmsi", func(c *gin.Context) { getShipData(c, db) })
+	dateScoped.GET("/ships/:mmsi", func(c *gin.Context) { getShipData(c, db) })
+
+	// Example: GET /ships/273450000/track?from=2023-09-01&to=2023-09-03
+	// Returns the ordered lat/lon polyline for a given MMSI
+	dateScoped.GET("/ships/:mmsi/track", func(c *gin.Context) { getShipTrack(c, db) })
 
 	// Example: GET /ships/mmsi?from=2023-09-01
 	// Returns a distinct list of all MMSIs present in the dataset for given date(s)
-	router.GET("/ships/mmsi", func(c *gin.Context) { getUniqueMMSI(c, db) })
+	dateScoped.GET("/ships/mmsi", func(c *gin.Context) { getUniqueMMSI(c, db) })
 
 	// Example: GET /latest?from=2023-09-01
 	// Returns the 10 most recent messages across all ships
-	router.GET("/latest", func(c *gin.Context) { getLatestAllShips(c, db) })
+	dateScoped.GET("/latest", func(c *gin.Context) { getLatestAllShips(c, db) })
+
+	// Example: GET /bbox?minLat=25&maxLat=48&minLon=-21&maxLon=45&from=2023-09-01
+	// Returns all messages within a spatial bounding box, pushed down to DuckDB
+	dateScoped.GET("/bbox", func(c *gin.Context) { getBoundingBox(c, db) })
 
 	// Example: GET /stats?from=2023-09-01
 	// Returns summary statistics (total messages, frequency, etc.)
-	router.GET("/stats", func(c *gin.Context) { getStats(c, db) })
+	dateScoped.GET("/stats", func(c *gin.Context) { getStats(c, db) })
 
 	// Run HTTP server asynchronously
 	server := &http.Server{
@@ -67,6 +105,21 @@ func runAPI(db *sql.DB, ctx context.Context) {
 	server.Shutdown(context.Background())
 }
 
+// middlewareRequestMetrics times every request and records it under its
+// matched route pattern (e.g. "/ships/:mmsi"), so per-endpoint latency shows
+// up on /metrics without per-handler instrumentation.
+func middlewareRequestMetrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequestDuration.WithLabelValues(route, c.Request.Method, fmt.Sprintf("%d", c.Writer.Status())).
+		Observe(time.Since(start).Seconds())
+}
+
 // middlewareDateRange parses the 'from' and 'to' date parameters (ISO 8601, e.g., 2023-09-01),
 // defaults to the current UTC day if not provided, and finds matching Parquet files by date.
 //
@@ -108,8 +161,10 @@ func middlewareDateRange(c *gin.Context) {
 	c.Next()
 }
 
-// getFilePaths returns all Parquet file paths under 'ais_data/' that match the given date range.
-// Expected file format: ais_data/YYYY-MM-DD_*.parquet
+// getFilePaths returns all Parquet file paths covering the given date range.
+// For each date it prefers the single compacted file under
+// ais_data/compacted/ (see compact.go) if one exists, falling back to that
+// date's hourly shards (ais_data/YYYY-MM-DD_*.parquet) otherwise.
 func getFilePaths(from, to string) []string {
 	var files []string
 	layout := "2006-01-02"
@@ -118,14 +173,88 @@ func getFilePaths(from, to string) []string {
 	end, _ := time.Parse(layout, to)
 
 	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
-		pattern := fmt.Sprintf("ais_data/%s_*.parquet", d.Format(layout))
-		matches, _ := filepath.Glob(pattern)
+		date := d.Format(layout)
+
+		compacted := filepath.Join(compactedDir, date+".parquet")
+		if _, err := os.Stat(compacted); err == nil {
+			files = append(files, compacted)
+			continue
+		}
+
+		matches, _ := filepath.Glob(fmt.Sprintf("ais_data/%s_*.parquet", date))
 		files = append(files, matches...)
 	}
 
 	return files
 }
 
+// aisRecordColumns lists the typed columns selected from the AISRecord
+// Parquet schema, shared by every handler that returns raw message rows.
+const aisRecordColumns = `timestamp, mmsi, message_type, lat, lon, sog, cog, true_heading,
+		nav_status, ship_name, destination, draught, imo_number, call_sign, ship_type, raw_json`
+
+// scanAISRow reads one row shaped like aisRecordColumns into a gin.H,
+// omitting any column that is NULL for this message's type.
+func scanAISRow(rows *sql.Rows) (gin.H, error) {
+	var (
+		timestamp, mmsi, messageType                string
+		lat, lon, sog, cog, draught                 sql.NullFloat64
+		trueHeading, navStatus, imoNumber, shipType sql.NullInt64
+		shipName, destination, callSign, rawJSON    sql.NullString
+	)
+
+	if err := rows.Scan(&timestamp, &mmsi, &messageType, &lat, &lon, &sog, &cog, &trueHeading,
+		&navStatus, &shipName, &destination, &draught, &imoNumber, &callSign, &shipType, &rawJSON); err != nil {
+		return nil, err
+	}
+
+	result := gin.H{
+		"timestamp":    timestamp,
+		"mmsi":         mmsi,
+		"message_type": messageType,
+	}
+	if lat.Valid {
+		result["lat"] = lat.Float64
+	}
+	if lon.Valid {
+		result["lon"] = lon.Float64
+	}
+	if sog.Valid {
+		result["sog"] = sog.Float64
+	}
+	if cog.Valid {
+		result["cog"] = cog.Float64
+	}
+	if trueHeading.Valid {
+		result["true_heading"] = trueHeading.Int64
+	}
+	if navStatus.Valid {
+		result["nav_status"] = navStatus.Int64
+	}
+	if shipName.Valid {
+		result["ship_name"] = shipName.String
+	}
+	if destination.Valid {
+		result["destination"] = destination.String
+	}
+	if draught.Valid {
+		result["draught"] = draught.Float64
+	}
+	if imoNumber.Valid {
+		result["imo_number"] = imoNumber.Int64
+	}
+	if callSign.Valid {
+		result["call_sign"] = callSign.String
+	}
+	if shipType.Valid {
+		result["ship_type"] = shipType.Int64
+	}
+	if rawJSON.Valid {
+		result["raw_json"] = rawJSON.String
+	}
+	return result, nil
+}
+
 // getShipData queries all messages from Parquet files for a specific MMSI (Maritime Mobile Service Identity).
 //
 // DuckDB reads multiple Parquet files via `read_parquet(ARRAY[...])` syntax,
@@ -138,9 +267,44 @@ func getShipData(c *gin.Context, db *sql.DB) {
 
 	fileList := "ARRAY['" + strings.Join(files, "', '") + "']"
 	query := fmt.Sprintf(`
-		SELECT timestamp, mmsi, raw_json 
-		FROM read_parquet(%s) 
-		WHERE mmsi = ?`, fileList)
+		SELECT %s
+		FROM read_parquet(%s, union_by_name := true)
+		WHERE mmsi = ?`, aisRecordColumns, fileList)
+
+	rows, err := db.Query(query, mmsi)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var results []gin.H
+	for rows.Next() {
+		row, err := scanAISRow(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		results = append(results, row)
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// getShipTrack returns the ordered (lat, lon) polyline for a given MMSI over
+// the requested date range, drawn from every row for that MMSI that has a
+// location, whatever its message type.
+//
+// Example: GET /ships/273450000/track?from=2023-09-01&to=2023-09-03
+func getShipTrack(c *gin.Context, db *sql.DB) {
+	mmsi := c.Param("mmsi")
+	files := c.MustGet("files").([]string)
+
+	fileList := "ARRAY['" + strings.Join(files, "', '") + "']"
+	query := fmt.Sprintf(`
+		SELECT timestamp, lat, lon
+		FROM read_parquet(%s, union_by_name := true)
+		WHERE mmsi = ? AND lat IS NOT NULL AND lon IS NOT NULL
+		ORDER BY timestamp ASC`, fileList)
 
 	rows, err := db.Query(query, mmsi)
 	if err != nil {
@@ -149,17 +313,56 @@ func getShipData(c *gin.Context, db *sql.DB) {
 	}
 	defer rows.Close()
 
-	var results []map[string]interface{}
+	var track []gin.H
 	for rows.Next() {
 		var timestamp string
-		var mmsi int
-		var rawData string
-		rows.Scan(&timestamp, &mmsi, &rawData)
-		results = append(results, gin.H{
-			"timestamp": timestamp,
-			"mmsi":      mmsi,
-			"raw_data":  rawData,
-		})
+		var lat, lon float64
+		if err := rows.Scan(&timestamp, &lat, &lon); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		track = append(track, gin.H{"timestamp": timestamp, "lat": lat, "lon": lon})
+	}
+	c.JSON(http.StatusOK, gin.H{"mmsi": mmsi, "track": track})
+}
+
+// getBoundingBox returns every message within the given lat/lon box for the
+// requested date range, pushing the spatial filter down to DuckDB.
+//
+// Example: GET /bbox?minLat=25&maxLat=48&minLon=-21&maxLon=45&from=2023-09-01
+func getBoundingBox(c *gin.Context, db *sql.DB) {
+	files := c.MustGet("files").([]string)
+
+	minLat, err1 := strconv.ParseFloat(c.Query("minLat"), 64)
+	maxLat, err2 := strconv.ParseFloat(c.Query("maxLat"), 64)
+	minLon, err3 := strconv.ParseFloat(c.Query("minLon"), 64)
+	maxLon, err4 := strconv.ParseFloat(c.Query("maxLon"), 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "minLat, maxLat, minLon and maxLon are required numeric query params"})
+		return
+	}
+
+	fileList := "ARRAY['" + strings.Join(files, "', '") + "']"
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM read_parquet(%s, union_by_name := true)
+		WHERE lat BETWEEN ? AND ? AND lon BETWEEN ? AND ?`, aisRecordColumns, fileList)
+
+	rows, err := db.Query(query, minLat, maxLat, minLon, maxLon)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var results []gin.H
+	for rows.Next() {
+		row, err := scanAISRow(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		results = append(results, row)
 	}
 	c.JSON(http.StatusOK, results)
 }
@@ -171,7 +374,7 @@ func getUniqueMMSI(c *gin.Context, db *sql.DB) {
 	files := c.MustGet("files").([]string)
 
 	fileList := "ARRAY['" + strings.Join(files, "', '") + "']"
-	query := fmt.Sprintf("SELECT DISTINCT mmsi FROM read_parquet(%s)", fileList)
+	query := fmt.Sprintf("SELECT DISTINCT mmsi FROM read_parquet(%s, union_by_name := true)", fileList)
 
 	rows, err := db.Query(query)
 	if err != nil {
@@ -198,10 +401,10 @@ func getLatestAllShips(c *gin.Context, db *sql.DB) {
 
 	fileList := "ARRAY['" + strings.Join(files, "', '") + "']"
 	query := fmt.Sprintf(`
-		SELECT timestamp, mmsi, raw_json 
-		FROM read_parquet(%s) 
-		ORDER BY timestamp DESC 
-		LIMIT 10`, fileList)
+		SELECT %s
+		FROM read_parquet(%s, union_by_name := true)
+		ORDER BY timestamp DESC
+		LIMIT 10`, aisRecordColumns, fileList)
 
 	rows, err := db.Query(query)
 	if err != nil {
@@ -210,17 +413,14 @@ func getLatestAllShips(c *gin.Context, db *sql.DB) {
 	}
 	defer rows.Close()
 
-	var results []map[string]interface{}
+	var results []gin.H
 	for rows.Next() {
-		var timestamp string
-		var mmsi int
-		var rawData string
-		rows.Scan(&timestamp, &mmsi, &rawData)
-		results = append(results, gin.H{
-			"timestamp": timestamp,
-			"mmsi":      mmsi,
-			"raw_data":  rawData,
-		})
+		row, err := scanAISRow(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		results = append(results, row)
 	}
 	c.JSON(http.StatusOK, results)
 }
@@ -237,10 +437,10 @@ func getStats(c *gin.Context, db *sql.DB) {
 	files := c.MustGet("files").([]string)
 	fileList := "ARRAY['" + strings.Join(files, "', '") + "']"
 
-	queryTotal := fmt.Sprintf("SELECT COUNT(*) FROM read_parquet(%s)", fileList)
-	queryLastHour := fmt.Sprintf("SELECT COUNT(*) FROM read_parquet(%s) WHERE timestamp >= CAST(NOW() AS TIMESTAMP) - INTERVAL '1 hour'", fileList)
-	queryLastMinute := fmt.Sprintf("SELECT COUNT(*) FROM read_parquet(%s) WHERE timestamp >= CAST(NOW() AS TIMESTAMP) - INTERVAL '1 minute'", fileList)
-	queryAvgPerMinute := fmt.Sprintf("SELECT CAST(COUNT(*) / 1440 AS INTEGER) FROM read_parquet(%s)", fileList)
+	queryTotal := fmt.Sprintf("SELECT COUNT(*) FROM read_parquet(%s, union_by_name := true)", fileList)
+	queryLastHour := fmt.Sprintf("SELECT COUNT(*) FROM read_parquet(%s, union_by_name := true) WHERE timestamp >= CAST(NOW() AS TIMESTAMP) - INTERVAL '1 hour'", fileList)
+	queryLastMinute := fmt.Sprintf("SELECT COUNT(*) FROM read_parquet(%s, union_by_name := true) WHERE timestamp >= CAST(NOW() AS TIMESTAMP) - INTERVAL '1 minute'", fileList)
+	queryAvgPerMinute := fmt.Sprintf("SELECT CAST(COUNT(*) / 1440 AS INTEGER) FROM read_parquet(%s, union_by_name := true)", fileList)
 
 	var total, lastHour, lastMinute, avgPerMinute int
 
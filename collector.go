@@ -6,14 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/parquet"
-	"github.com/xitongsys/parquet-go/writer"
 )
 
 // AISSTREAM_URL specifies the WebSocket endpoint for real-time AIS data stream.
@@ -25,52 +21,118 @@ const FLUSH_INTERVAL = 60 * time.Minute
 // MaxRecordsPerFile sets the upper limit of buffered AIS records per Parquet file before a flush is triggered.
 const MaxRecordsPerFile = 100000
 
-// Global state: protected buffer and counters
+// Global state: protected counters and the sink fan-out.
 var (
-	mu             sync.Mutex // Ensures safe concurrent access to in-memory buffer
-	recordCount    int        // Tracks the number of buffered records
+	mu             sync.Mutex // Guards recordCount/lastLogPercent below
+	recordCount    int        // Tracks records handled since the count last wrapped at MaxRecordsPerFile
 	lastLogPercent int        // Used to log buffer progress in 10% increments
+
+	outputs *OutputManager // Fan-out to every enabled Output sink
+	wal     *WAL           // Write-ahead log guarding against buffer loss on crash
 )
 
-// AISRecord defines the in-memory structure of a single AIS message,
-// and the schema for writing to Apache Parquet format using `parquet-go` tags.
+// includeRawJSON controls whether the raw_json debugging column is populated
+// alongside the typed columns below. It's set once from Config at startup.
+var includeRawJSON bool
+
+// AISRecord defines the in-memory structure of a single decoded AIS message,
+// and the schema for writing to Apache Parquet format using `parquet-go`
+// tags. It is also the unit dispatched to every configured Output.
+//
+// Only the fields relevant to the message's MessageType are populated; the
+// rest are left nil and stored as Parquet NULLs.
 type AISRecord struct {
-	Timestamp string `parquet:"name=timestamp, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN"`
-	MMSI      string `parquet:"name=mmsi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN"`
-	RawJSON   string `parquet:"name=raw_json, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN"`
+	Timestamp   string   `parquet:"name=timestamp, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN"`
+	MMSI        string   `parquet:"name=mmsi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN"`
+	MessageType string   `parquet:"name=message_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN"`
+	Lat         *float64 `parquet:"name=lat, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Lon         *float64 `parquet:"name=lon, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Sog         *float64 `parquet:"name=sog, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Cog         *float64 `parquet:"name=cog, type=DOUBLE, repetitiontype=OPTIONAL"`
+	TrueHeading *int32   `parquet:"name=true_heading, type=INT32, repetitiontype=OPTIONAL"`
+	NavStatus   *int32   `parquet:"name=nav_status, type=INT32, repetitiontype=OPTIONAL"`
+	ShipName    *string  `parquet:"name=ship_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Destination *string  `parquet:"name=destination, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Draught     *float64 `parquet:"name=draught, type=DOUBLE, repetitiontype=OPTIONAL"`
+	ImoNumber   *int32   `parquet:"name=imo_number, type=INT32, repetitiontype=OPTIONAL"`
+	CallSign    *string  `parquet:"name=call_sign, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	ShipType    *int32   `parquet:"name=ship_type, type=INT32, repetitiontype=OPTIONAL"`
+	RawJSON     *string  `parquet:"name=raw_json, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+
+	// walSeq is the WAL sequence number this record was appended under (see
+	// wal.go), carried alongside it through the Output fan-out so each
+	// sinkRunner can report how far it's durably flushed. OutputManager
+	// checkpoints the WAL once every configured sink has confirmed at
+	// least that far. Unexported: it's bookkeeping, not part of the
+	// Parquet schema or the JSON written to the WAL itself.
+	walSeq uint64
 }
 
-// aisRecords acts as an in-memory buffer accumulating raw AIS messages for periodic batch write.
-var aisRecords []AISRecord
+// initCollectorState loads the output sink configuration, starts each
+// enabled sink, and opens the write-ahead log, replaying any records not
+// yet confirmed flushed by every sink. It's shared by both the live WebSocket
+// collector and --replay, so replayed traffic flows through the exact same
+// outputs/WAL machinery as live traffic.
+func initCollectorState(configPath, walMode string) error {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	includeRawJSON = cfg.IncludeRawJSON
+	outputs = NewOutputManager(cfg.Outputs)
+	outputs.OnCheckpoint = func(upToSeq uint64) { wal.Checkpoint(upToSeq) }
+
+	wal, err = NewWAL(walMode)
+	if err != nil {
+		return fmt.Errorf("initializing WAL: %w", err)
+	}
+	replayWAL()
+	return nil
+}
 
-// runCollector initializes the AIS data collection process:
-// - Connects to a WebSocket stream
-// - Buffers messages in memory
-// - Periodically flushes to disk in Parquet format
-func runCollector(apiKey string, db *sql.DB, ctx context.Context) {
+// runCollector initializes the AIS data collection process and connects to
+// the live WebSocket stream, dispatching every decoded message to the
+// configured Output(s) until ctx is canceled.
+func runCollector(apiKey string, db *sql.DB, ctx context.Context, configPath string, walMode string) {
 	log.Println("Starting AIS Data Collector...")
 
-	// Ensure output directory exists
-	if err := os.MkdirAll("ais_data", os.ModePerm); err != nil {
-		log.Fatal("Failed to create directory:", err)
+	if err := initCollectorState(configPath, walMode); err != nil {
+		log.Fatal(err)
 	}
 
 	// Launch non-blocking WebSocket listener
 	go connectWebSocket(apiKey, db)
 
-	// Start periodic flush cycle
-	ticker := time.NewTicker(FLUSH_INTERVAL)
-	defer ticker.Stop()
+	<-ctx.Done()
+	log.Println("Stopping AIS Data Collector...")
+	outputs.Close()
+	if err := wal.Close(); err != nil {
+		log.Println("Error closing WAL:", err)
+	}
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Stopping AIS Data Collector...")
-			return
-		case <-ticker.C:
-			saveToParquet(db)
-		}
+// replayWAL recovers any records written to the WAL but not yet confirmed
+// flushed by every sink (e.g. after a crash between flushes), re-dispatches
+// them through the normal Output fan-out, forces an immediate flush so they
+// land on disk before new traffic starts arriving, and checkpoints the WAL
+// up to the replayed sequence number so the same records aren't replayed
+// again on the next crash.
+func replayWAL() {
+	records, maxSeq, err := wal.Replay()
+	if err != nil {
+		log.Println("WAL replay error:", err)
+		return
+	}
+	if len(records) == 0 {
+		return
 	}
+
+	log.Printf("WAL replay: recovering %d record(s) from before the last crash", len(records))
+	for _, rec := range records {
+		outputs.Write(rec)
+	}
+	outputs.FlushAll()
+	wal.Checkpoint(maxSeq)
 }
 
 // connectWebSocket manages the persistent WebSocket connection to the AIS stream provider.
@@ -79,6 +141,7 @@ func runCollector(apiKey string, db *sql.DB, ctx context.Context) {
 func connectWebSocket(apiKey string, db *sql.DB) {
 	for {
 		log.Println("Connecting to AIS WebSocket...")
+		websocketReconnectsTotal.Inc()
 
 		conn, _, err := websocket.DefaultDialer.Dial(AISSTREAM_URL, nil)
 		if err != nil {
@@ -86,6 +149,7 @@ func connectWebSocket(apiKey string, db *sql.DB) {
 			time.Sleep(5 * time.Second)
 			continue
 		}
+		websocketConnected.Set(1)
 
 		// Prepare subscription payload with API key and bounding box filter
 		subscription := map[string]interface{}{
@@ -97,6 +161,7 @@ func connectWebSocket(apiKey string, db *sql.DB) {
 		if err = conn.WriteMessage(websocket.TextMessage, subData); err != nil {
 			log.Println("Subscription error:", err)
 			conn.Close()
+			websocketConnected.Set(0)
 			continue
 		}
 
@@ -108,6 +173,7 @@ func connectWebSocket(apiKey string, db *sql.DB) {
 			if err != nil {
 				log.Println("WebSocket read error:", err)
 				conn.Close()
+				websocketConnected.Set(0)
 				break
 			}
 			handleAISMessage(message, db)
@@ -118,124 +184,76 @@ func connectWebSocket(apiKey string, db *sql.DB) {
 	}
 }
 
-// handleAISMessage decodes incoming raw AIS JSON messages,
-// extracts the MMSI identifier, and stores them in the buffer.
+// handleAISMessage decodes incoming raw AIS JSON messages, extracts the MMSI
+// identifier, and dispatches the resulting AISRecord to every configured
+// Output sink, then fans it out to any live /stream subscribers. Each sink
+// batches and flushes independently, so a slow or failing sink never blocks
+// message handling; likewise a slow stream subscriber only drops its own
+// buffered records instead of blocking the collector.
 //
-// Automatically triggers a flush when the record limit is reached.
+// The record's Timestamp is stamped with the current wall-clock time, since
+// this is the live ingestion path. Use handleAISMessageAt to preserve an
+// original event time instead (see replay.go).
 func handleAISMessage(message []byte, db *sql.DB) {
+	handleAISMessageAt(message, db, "")
+}
+
+// handleAISMessageAt is handleAISMessage with an explicit record timestamp.
+// An empty timestamp falls back to time.Now(), matching handleAISMessage's
+// live-ingestion behavior; runReplayer passes the archived row's original
+// timestamp so re-archived or re-published replay data doesn't collapse
+// onto the time it happened to be replayed.
+func handleAISMessageAt(message []byte, db *sql.DB, timestamp string) {
 	var data map[string]interface{}
 	err := json.Unmarshal(message, &data)
 	if err != nil {
 		log.Println("JSON parse error:", err)
+		messagesDroppedTotal.WithLabelValues("invalid_json").Inc()
 		return
 	}
 
-	mmsi := extractMMSI(data)
-	if mmsi == "" {
+	messageType, _ := data["MessageType"].(string)
+	messagesReceivedTotal.WithLabelValues(messageType).Inc()
+
+	record, ok := decodeAISMessage(data, messageType)
+	if !ok {
+		messagesDroppedTotal.WithLabelValues("unhandled_message_type").Inc()
 		return
 	}
+	if timestamp != "" {
+		record.Timestamp = timestamp
+	} else {
+		record.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	}
+	if includeRawJSON {
+		raw := string(message)
+		record.RawJSON = &raw
+	}
 
-	record := AISRecord{
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		MMSI:      mmsi,
-		RawJSON:   string(message),
+	seq, err := wal.Append(record)
+	if err != nil {
+		log.Println("WAL append error:", err)
 	}
+	record.walSeq = seq
+	outputs.Write(record)
+	streamHub.Publish(record)
 
 	mu.Lock()
-	aisRecords = append(aisRecords, record)
 	recordCount++
-	mu.Unlock()
-
-	// Log buffer progress in 10% steps
 	currentPercent := (recordCount * 100) / MaxRecordsPerFile
 	if currentPercent/10 != lastLogPercent/10 {
-		log.Printf("Buffer fill: %d%% (%d/%d)\n", currentPercent, recordCount, MaxRecordsPerFile)
+		log.Printf("Messages handled: %d%% of %d in the current cycle\n", currentPercent, MaxRecordsPerFile)
 		lastLogPercent = currentPercent
 	}
-
-	// Auto-flush on capacity
 	if recordCount >= MaxRecordsPerFile {
-		saveToParquet(db)
+		recordCount = 0
+		lastLogPercent = 0
 	}
+	mu.Unlock()
 }
 
-// extractMMSI traverses the nested AIS JSON payload and extracts the numeric UserID (MMSI).
-// Returns empty string if the expected fields are missing or malformed.
-func extractMMSI(data map[string]interface{}) string {
-	msg, ok := data["Message"].(map[string]interface{})
-	if !ok {
-		return ""
-	}
-	pos, ok := msg["PositionReport"].(map[string]interface{})
-	if !ok {
-		return ""
-	}
-	mmsi, ok := pos["UserID"].(float64)
-	if !ok {
-		return ""
-	}
-	return fmt.Sprintf("%.0f", mmsi)
-}
-
-// saveToParquet serializes the current in-memory buffer of AIS records
-// into a compressed Parquet file using ZSTD codec.
-//
-// Uses parquet-go with local file output backend.
-func saveToParquet(db *sql.DB) {
-	log.Println("Locking in-memory buffer for Parquet save process")
-	mu.Lock()
-	defer mu.Unlock()
-
-	if recordCount == 0 {
-		log.Println("No data to save, skipping Parquet write.")
-		return
-	}
-
-	currentFile := getNewFilePath()
-	log.Printf("Saving %d records to Parquet file: %s", recordCount, currentFile)
-
-	// Create local Parquet file writer
-	fw, err := local.NewLocalFileWriter(currentFile)
-	if err != nil {
-		log.Println("Failed to create Parquet file writer:", err)
-		return
-	}
-
-	pw, err := writer.NewParquetWriter(fw, new(AISRecord), 4)
-	if err != nil {
-		log.Println("Error initializing Parquet writer:", err)
-		return
-	}
-	pw.CompressionType = parquet.CompressionCodec_ZSTD
-
-	startTime := time.Now()
-	for _, rec := range aisRecords {
-		if err = pw.Write(rec); err != nil {
-			log.Println("Parquet write error:", err)
-		}
-	}
-	if err = pw.WriteStop(); err != nil {
-		log.Println("Error finalizing Parquet writer:", err)
-		return
-	}
-
-	log.Printf("Parquet file %s written successfully in %.2f seconds.", currentFile, time.Since(startTime).Seconds())
-
-	// Report file size
-	if fileInfo, err := os.Stat(currentFile); err == nil {
-		log.Printf("Parquet file size: %.2f MB", float64(fileInfo.Size())/1024/1024)
-	} else {
-		log.Println("Could not retrieve file size:", err)
-	}
-
-	// Reset state after successful save
-	aisRecords = nil
-	recordCount = 0
-	log.Println("In-memory buffer cleared after Parquet save.")
-}
-
-// getNewFilePath returns a timestamped Parquet filename in `ais_data/` directory.
-// Format: ais_data/YYYY-MM-DD_HH-MM-SS.parquet
-func getNewFilePath() string {
-	return fmt.Sprintf("ais_data/%s.parquet", time.Now().Format("2006-01-02_15-04-05"))
+// formatMMSI renders a UserID field (decoded as float64 by encoding/json)
+// as the canonical string MMSI used across records and API responses.
+func formatMMSI(userID float64) string {
+	return fmt.Sprintf("%.0f", userID)
 }
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// compactedDir holds one merged Parquet file per UTC date, produced by
+// runCompactor from that date's hourly shards under ais_data/.
+const compactedDir = "ais_data/compacted"
+
+// compactedRowGroupSize is set well above parquet-go's 128MB default so a
+// whole day's worth of records typically lands in a single row group,
+// trading a little query-side predicate pushdown for fewer, larger groups.
+const compactedRowGroupSize = 512 * 1024 * 1024
+
+// defaultCompactionThreshold is how old a date's hourly shards must be,
+// relative to now, before that date is considered eligible for compaction.
+// It defaults to a full day so a date still receiving traffic (e.g. "today"
+// in a timezone ahead of UTC) is never compacted out from under a writer.
+const defaultCompactionThreshold = 24 * time.Hour
+
+// compactedAISRecord mirrors AISRecord's columns exactly (same names, same
+// order, same types) so read_parquet sees an identical schema whichever file
+// it reads, but dictionary-encodes mmsi: within a single day a given ship
+// repeats across thousands of rows, so a dictionary shrinks the column far
+// more than plain encoding.
+type compactedAISRecord struct {
+	Timestamp   string   `parquet:"name=timestamp, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN"`
+	MMSI        string   `parquet:"name=mmsi, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	MessageType string   `parquet:"name=message_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN"`
+	Lat         *float64 `parquet:"name=lat, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Lon         *float64 `parquet:"name=lon, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Sog         *float64 `parquet:"name=sog, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Cog         *float64 `parquet:"name=cog, type=DOUBLE, repetitiontype=OPTIONAL"`
+	TrueHeading *int32   `parquet:"name=true_heading, type=INT32, repetitiontype=OPTIONAL"`
+	NavStatus   *int32   `parquet:"name=nav_status, type=INT32, repetitiontype=OPTIONAL"`
+	ShipName    *string  `parquet:"name=ship_name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Destination *string  `parquet:"name=destination, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	Draught     *float64 `parquet:"name=draught, type=DOUBLE, repetitiontype=OPTIONAL"`
+	ImoNumber   *int32   `parquet:"name=imo_number, type=INT32, repetitiontype=OPTIONAL"`
+	CallSign    *string  `parquet:"name=call_sign, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+	ShipType    *int32   `parquet:"name=ship_type, type=INT32, repetitiontype=OPTIONAL"`
+	RawJSON     *string  `parquet:"name=raw_json, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+
+	// walSeq matches AISRecord's unexported bookkeeping field so the two
+	// types convert directly; like there, it is not a Parquet column.
+	walSeq uint64
+}
+
+// compactionThreshold mirrors the threshold runCompactor was started with,
+// so compactNow can apply the same "not still being written to" guard to
+// on-demand compactions. Defaults to defaultCompactionThreshold for the
+// (replay-mode) case where the background compactor never starts.
+var compactionThreshold = defaultCompactionThreshold
+
+// runCompactor periodically merges hourly Parquet shards into one
+// dictionary-encoded, mmsi-and-timestamp-sorted file per UTC date, once that
+// date's shards are older than threshold (i.e. no longer being written to).
+// It's launched as its own background goroutine from main and runs until ctx
+// is canceled.
+func runCompactor(ctx context.Context, threshold time.Duration, db *sql.DB) {
+	compactionThreshold = threshold
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	compactEligibleDates(threshold, db)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			compactEligibleDates(threshold, db)
+		}
+	}
+}
+
+// compactEligibleDates finds every UTC date under ais_data/ whose hourly
+// shards are all older than threshold and not yet compacted, and compacts
+// each in turn. It also keeps compactionLagSeconds up to date with the
+// oldest date still awaiting compaction.
+func compactEligibleDates(threshold time.Duration, db *sql.DB) {
+	dates, err := pendingCompactionDates(threshold)
+	if err != nil {
+		log.Println("compactor: scanning ais_data:", err)
+		return
+	}
+
+	if len(dates) == 0 {
+		compactionLagSeconds.Set(0)
+		return
+	}
+
+	sort.Strings(dates)
+	oldest, _ := time.Parse("2006-01-02", dates[0])
+	compactionLagSeconds.Set(time.Since(oldest).Seconds())
+
+	for _, date := range dates {
+		if err := compactDate(date, db); err != nil {
+			log.Printf("compactor: compacting %s: %v", date, err)
+		}
+	}
+}
+
+// pendingCompactionDates lists every UTC date with hourly shards under
+// ais_data/ that are all older than threshold and don't already have a
+// compacted file.
+func pendingCompactionDates(threshold time.Duration) ([]string, error) {
+	matches, err := filepath.Glob("ais_data/*.parquet")
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-threshold)
+	newestByDate := map[string]time.Time{}
+	for _, path := range matches {
+		date := filepath.Base(path)[:len("2006-01-02")]
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(newestByDate[date]) {
+			newestByDate[date] = info.ModTime()
+		}
+	}
+
+	var dates []string
+	for date, newest := range newestByDate {
+		if newest.After(cutoff) {
+			continue // still receiving writes; not eligible yet
+		}
+		if _, err := os.Stat(filepath.Join(compactedDir, date+".parquet")); err == nil {
+			continue // already compacted
+		}
+		dates = append(dates, date)
+	}
+	return dates, nil
+}
+
+// compactDate merges every hourly shard for date into
+// ais_data/compacted/date.parquet, sorted by (mmsi, timestamp), then removes
+// the source shards. The merged file is written to a temporary path and
+// renamed into place so a crash mid-compaction never leaves a partial file
+// where a reader expects a finished one.
+func compactDate(date string, db *sql.DB) error {
+	files, err := filepath.Glob(fmt.Sprintf("ais_data/%s_*.parquet", date))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	rows, err := loadCompactionRows(files, db)
+	if err != nil {
+		return fmt.Errorf("loading rows: %w", err)
+	}
+
+	if err := os.MkdirAll(compactedDir, os.ModePerm); err != nil {
+		return fmt.Errorf("creating compacted dir: %w", err)
+	}
+
+	finalPath := filepath.Join(compactedDir, date+".parquet")
+	tmpPath := finalPath + ".tmp"
+
+	if err := writeCompactedFile(tmpPath, rows); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("writing compacted file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		return fmt.Errorf("renaming compacted file into place: %w", err)
+	}
+	if err := fsyncDir(compactedDir); err != nil {
+		log.Println("compactor: fsync compacted dir:", err)
+	}
+
+	for _, f := range files {
+		if err := os.Remove(f); err != nil {
+			log.Printf("compactor: removing source shard %s: %v", f, err)
+		}
+	}
+	if err := fsyncDir("ais_data"); err != nil {
+		log.Println("compactor: fsync ais_data dir:", err)
+	}
+
+	log.Printf("compactor: merged %d shard(s) for %s into %s (%d records)", len(files), date, finalPath, len(rows))
+	return nil
+}
+
+// loadCompactionRows reads every row from files, sorted by (mmsi,
+// timestamp) so the compacted file is physically clustered by ship.
+func loadCompactionRows(files []string, db *sql.DB) ([]replayRow, error) {
+	quoted := make([]string, len(files))
+	for i, f := range files {
+		quoted[i] = "'" + filepath.ToSlash(f) + "'"
+	}
+	fileList := "ARRAY[" + strings.Join(quoted, ", ") + "]"
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM read_parquet(%s, union_by_name := true)
+		ORDER BY mmsi, timestamp ASC`, aisRecordColumns, fileList)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []replayRow
+	for rows.Next() {
+		var r replayRow
+		if err := rows.Scan(&r.Timestamp, &r.MMSI, &r.MessageType, &r.Lat, &r.Lon, &r.Sog, &r.Cog,
+			&r.TrueHeading, &r.NavStatus, &r.ShipName, &r.Destination, &r.Draught, &r.ImoNumber,
+			&r.CallSign, &r.ShipType, &r.RawJSON); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// writeCompactedFile writes rows to path as a single dictionary-encoded
+// Parquet file with an enlarged row group size, fsync'd before return.
+func writeCompactedFile(path string, rows []replayRow) error {
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return fmt.Errorf("creating parquet file writer: %w", err)
+	}
+
+	pw, err := writer.NewParquetWriter(fw, new(compactedAISRecord), 4)
+	if err != nil {
+		fw.Close()
+		return fmt.Errorf("initializing parquet writer: %w", err)
+	}
+	pw.CompressionType = parquet.CompressionCodec_ZSTD
+	pw.RowGroupSize = compactedRowGroupSize
+
+	for _, r := range rows {
+		rec := compactedAISRecord(r.toAISRecord())
+		if err := pw.Write(rec); err != nil {
+			log.Println("compactor: parquet write error:", err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		fw.Close()
+		return fmt.Errorf("finalizing parquet file %s: %w", path, err)
+	}
+	fw.Close()
+
+	return fsyncFile(path)
+}
+
+// fsyncFile opens path and fsyncs it, for durability of a just-closed write.
+func fsyncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// fsyncDir fsyncs a directory so a preceding rename or remove within it is
+// durable, not just visible.
+func fsyncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// compactNow handles GET /admin/compact?date=YYYY-MM-DD, triggering an
+// immediate out-of-band compaction of that date. It's meant for operators
+// backfilling or re-running a failed compaction, not for routine use.
+//
+// By default it refuses to compact a date whose hourly shards aren't all
+// past compactionThreshold yet, since compactDate deletes the source shards
+// on success and a concurrent API query could be reading one of them. Pass
+// force=true to override, e.g. to backfill a date known to have stopped
+// receiving traffic early.
+func compactNow(c *gin.Context, db *sql.DB) {
+	date := c.Query("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be in YYYY-MM-DD format"})
+		return
+	}
+
+	if c.Query("force") != "true" {
+		eligible, err := dateShardsOlderThan(date, compactionThreshold)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !eligible {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf(
+				"%s has shards younger than %s and may still be receiving writes; pass force=true to compact anyway",
+				date, compactionThreshold)})
+			return
+		}
+	}
+
+	if err := compactDate(date, db); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"date": date, "status": "compacted"})
+}
+
+// dateShardsOlderThan reports whether every hourly shard for date was last
+// modified more than threshold ago, i.e. the date is no longer being
+// actively written to.
+func dateShardsOlderThan(date string, threshold time.Duration) (bool, error) {
+	files, err := filepath.Glob(fmt.Sprintf("ais_data/%s_*.parquet", date))
+	if err != nil {
+		return false, err
+	}
+
+	cutoff := time.Now().Add(-threshold)
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(cutoff) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the root of the service's YAML config file. It describes output
+// sinks and the raw_json debugging toggle; collector/API tuning still lives
+// in flags and constants, but this is the natural place for those to move to
+// as they grow.
+type Config struct {
+	Outputs []OutputConfig `yaml:"outputs"`
+
+	// IncludeRawJSON keeps the original message alongside the typed columns
+	// in every AISRecord, for debugging decode gaps. Off by default once the
+	// typed columns cover the fields most consumers need.
+	IncludeRawJSON bool `yaml:"include_raw_json"`
+}
+
+// OutputConfig describes a single configured Output sink.
+type OutputConfig struct {
+	Name      string                 `yaml:"name"`
+	Type      string                 `yaml:"type"`
+	Enabled   bool                   `yaml:"enabled"`
+	BatchSize int                    `yaml:"batch_size"`
+	Settings  map[string]interface{} `yaml:"settings"`
+
+	// FlushInterval is a Go duration string (e.g. "60s", "5m"). yaml.v3 has
+	// no special handling for time.Duration, so this is parsed explicitly by
+	// Duration() rather than declared as a time.Duration field, which would
+	// either fail to unmarshal "60s" or silently read bare numbers as
+	// nanoseconds.
+	FlushInterval string `yaml:"flush_interval"`
+}
+
+// Duration parses FlushInterval, returning zero if it's unset. Callers fall
+// back to their own default for a zero result.
+func (c OutputConfig) Duration() (time.Duration, error) {
+	if c.FlushInterval == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(c.FlushInterval)
+	if err != nil {
+		return 0, fmt.Errorf("output %q: invalid flush_interval %q: %w", c.Name, c.FlushInterval, err)
+	}
+	return d, nil
+}
+
+// defaultConfig is used when no config file is supplied: it preserves the
+// service's original behavior of writing Parquet files under ais_data/.
+func defaultConfig() *Config {
+	return &Config{
+		Outputs: []OutputConfig{
+			{
+				Name:          "parquet",
+				Type:          "parquet",
+				Enabled:       true,
+				BatchSize:     MaxRecordsPerFile,
+				FlushInterval: FLUSH_INTERVAL.String(),
+				Settings: map[string]interface{}{
+					"dir": "ais_data",
+				},
+			},
+		},
+	}
+}
+
+// LoadConfig reads and parses a YAML config file describing the enabled
+// output sinks. If path is empty, defaultConfig is returned so the service
+// keeps working with zero configuration.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return defaultConfig(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if len(cfg.Outputs) == 0 {
+		cfg.Outputs = defaultConfig().Outputs
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+// decodeAISMessage extracts an AISRecord from a parsed aisstream.io payload.
+// It understands the message sub-types that carry a UserID (MMSI): position
+// reports (Class A and B) and static/navigational-aid data. Any other
+// message type (e.g. voyage or base station reports) is not yet mapped to
+// typed columns and is dropped, same as the original MMSI-only extractor.
+func decodeAISMessage(data map[string]interface{}, messageType string) (AISRecord, bool) {
+	msg, ok := data["Message"].(map[string]interface{})
+	if !ok {
+		return AISRecord{}, false
+	}
+
+	sub, ok := msg[messageType].(map[string]interface{})
+	if !ok {
+		return AISRecord{}, false
+	}
+
+	mmsi, ok := getMMSI(sub)
+	if !ok {
+		return AISRecord{}, false
+	}
+
+	rec := AISRecord{
+		MMSI:        mmsi,
+		MessageType: messageType,
+	}
+
+	switch messageType {
+	case "PositionReport":
+		rec.Lat = getFloat(sub, "Latitude")
+		rec.Lon = getFloat(sub, "Longitude")
+		rec.Sog = getFloat(sub, "Sog")
+		rec.Cog = getFloat(sub, "Cog")
+		rec.TrueHeading = getInt32(sub, "TrueHeading")
+		rec.NavStatus = getInt32(sub, "NavigationalStatus")
+
+	case "StandardClassBPositionReport":
+		rec.Lat = getFloat(sub, "Latitude")
+		rec.Lon = getFloat(sub, "Longitude")
+		rec.Sog = getFloat(sub, "Sog")
+		rec.Cog = getFloat(sub, "Cog")
+		rec.TrueHeading = getInt32(sub, "TrueHeading")
+
+	case "AidsToNavigationReport":
+		rec.Lat = getFloat(sub, "Latitude")
+		rec.Lon = getFloat(sub, "Longitude")
+		rec.ShipName = getString(sub, "Name")
+
+	case "ShipStaticData":
+		rec.ShipName = getString(sub, "Name")
+		rec.Destination = getString(sub, "Destination")
+		rec.CallSign = getString(sub, "CallSign")
+		rec.Draught = getFloat(sub, "MaximumStaticDraught")
+		rec.ImoNumber = getInt32(sub, "ImoNumber")
+		rec.ShipType = getInt32(sub, "ShipType")
+
+	default:
+		return AISRecord{}, false
+	}
+
+	return rec, true
+}
+
+// getMMSI reads the UserID field common to every AIS message sub-type and
+// formats it the same way the original extractMMSI did.
+func getMMSI(sub map[string]interface{}) (string, bool) {
+	id, ok := sub["UserID"].(float64)
+	if !ok {
+		return "", false
+	}
+	return formatMMSI(id), true
+}
+
+// getFloat reads a float64 field as an optional Parquet column value.
+func getFloat(sub map[string]interface{}, key string) *float64 {
+	v, ok := sub[key].(float64)
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// getInt32 reads a numeric field, truncating from JSON's float64, as an
+// optional Parquet column value.
+func getInt32(sub map[string]interface{}, key string) *int32 {
+	v, ok := sub[key].(float64)
+	if !ok {
+		return nil
+	}
+	i := int32(v)
+	return &i
+}
+
+// getString reads a string field as an optional Parquet column value.
+func getString(sub map[string]interface{}, key string) *string {
+	v, ok := sub[key].(string)
+	if !ok || v == "" {
+		return nil
+	}
+	return &v
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -25,11 +27,23 @@ func newDBConnection() (*sql.DB, error) {
 }
 
 func main() {
+	// === Flags ===
+
+	configPath := flag.String("config", "", "path to the output sinks config file (YAML); defaults to a local Parquet sink")
+	walMode := flag.String("wal", "off", "write-ahead log durability mode: off, async, or sync")
+	replayGlob := flag.String("replay", "", "replay archived Parquet files matching this glob (e.g. ais_data/*.parquet) instead of connecting to the live WebSocket stream")
+	replaySpeed := flag.Float64("replay-speed", 1.0, "replay pace multiplier (1.0 = real-time, 0 = as fast as possible); only used with --replay")
+	compactAfter := flag.Duration("compact-after", defaultCompactionThreshold, "age a UTC date's hourly shards must reach before the background compactor merges them")
+	flag.Parse()
+
+	replayMode := *replayGlob != ""
+
 	// === Environment Validation ===
 
-	// AIS_API_KEY is required for authenticating with the AIS WebSocket stream.
+	// AIS_API_KEY is only needed to authenticate with the live WebSocket
+	// stream; replay mode never connects to it.
 	apiKey := os.Getenv("AIS_API_KEY")
-	if apiKey == "" {
+	if apiKey == "" && !replayMode {
 		log.Fatal("AIS_API_KEY is required")
 	}
 
@@ -59,15 +73,35 @@ func main() {
 	}
 	defer collectorDB.Close()
 
+	// A third connection dedicated to the background compactor, which reads
+	// and rewrites archived Parquet files independently of the other two.
+	compactorDB, err := newDBConnection()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer compactorDB.Close()
+
 	// === Concurrency Coordination ===
 
 	// Create a channel to synchronize the collector goroutine shutdown.
 	done := make(chan struct{})
 
-	// Launch the AIS collector as a background goroutine.
+	// Launch the AIS collector (or, with --replay, the replayer) as a
+	// background goroutine.
 	go func() {
 		defer close(done)
-		runCollector(apiKey, collectorDB, ctx)
+		if replayMode {
+			files, err := filepath.Glob(*replayGlob)
+			if err != nil || len(files) == 0 {
+				log.Fatalf("Replay: no files matched %q: %v", *replayGlob, err)
+			}
+			if err := initCollectorState(*configPath, *walMode); err != nil {
+				log.Fatal(err)
+			}
+			runReplayer(files, *replaySpeed, ctx, collectorDB)
+			return
+		}
+		runCollector(apiKey, collectorDB, ctx, *configPath, *walMode)
 	}()
 
 	// Allow the collector a short startup period before launching the API.
@@ -76,6 +110,15 @@ func main() {
 	// Launch the HTTP API in another background goroutine.
 	go runAPI(apiDB, ctx)
 
+	// Launch the background compactor, merging yesterday-and-older hourly
+	// shards into one file per date. Skipped in --replay mode: the files
+	// being replayed are themselves archived hourly shards, typically well
+	// past compactAfter, and the compactor would rewrite/delete them out
+	// from under the run reading them.
+	if !replayMode {
+		go runCompactor(ctx, *compactAfter, compactorDB)
+	}
+
 	// === Await Termination Signal ===
 
 	// Block main thread until the user sends SIGINT or SIGTERM.
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics exported by the collector and API. They're registered
+// on the default registry so a single /metrics endpoint on the API router
+// (see runAPI) exposes both collector- and API-side state.
+var (
+	messagesReceivedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ais_messages_received_total",
+		Help: "Total number of AIS messages received from the WebSocket stream, by message type.",
+	}, []string{"message_type"})
+
+	messagesDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ais_messages_dropped_total",
+		Help: "Total number of AIS messages dropped, by reason.",
+	}, []string{"reason"})
+
+	bufferRecords = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ais_buffer_records",
+		Help: "Number of AIS records currently buffered in a sink's pending batch, by sink.",
+	}, []string{"sink"})
+
+	bufferFillRatio = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ais_buffer_fill_ratio",
+		Help: "Fraction (0-1) of a sink's batch_size currently buffered, by sink.",
+	}, []string{"sink"})
+
+	parquetFlushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "ais_parquet_flush_duration_seconds",
+		Help: "Time taken to write a batch of records to a Parquet file.",
+	})
+
+	parquetFileBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ais_parquet_file_bytes",
+		Help:    "Size in bytes of each Parquet file written.",
+		Buckets: prometheus.ExponentialBuckets(1<<10, 4, 10), // 1KiB .. ~256MiB
+	})
+
+	websocketConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ais_websocket_connected",
+		Help: "Whether the AIS WebSocket stream is currently connected (1) or not (0).",
+	})
+
+	websocketReconnectsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ais_websocket_reconnects_total",
+		Help: "Total number of dial attempts made to (re)connect to the AIS WebSocket stream.",
+	})
+
+	compactionLagSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ais_compaction_lag_seconds",
+		Help: "Time since UTC midnight of the oldest date whose hourly Parquet shards are eligible for compaction, in seconds.",
+	})
+)
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Output is implemented by every sink that AIS records can be dispatched to.
+// Implementations are registered by name (see RegisterOutput) and instantiated
+// from the service configuration, mirroring the output-plugin model used by
+// tools like Telegraf: each sink owns its own batching/flush cadence and a
+// failure in one sink must never block or corrupt another.
+type Output interface {
+	// Init prepares the sink for writing using the settings declared for this
+	// output in the config file. It is called once, before the first Write.
+	Init(settings map[string]interface{}) error
+
+	// Write persists a batch of records. It may be called concurrently with
+	// Write calls to other Outputs, but never concurrently with itself.
+	Write(batch []AISRecord) error
+
+	// Close flushes any pending state and releases resources held by the sink.
+	Close() error
+}
+
+// outputFactories holds the registry of known Output constructors, keyed by
+// the `type` field used in the config file (e.g. "parquet", "kafka").
+var (
+	outputFactoriesMu sync.Mutex
+	outputFactories   = map[string]func() Output{}
+)
+
+// RegisterOutput makes an Output implementation available under name for use
+// in the config file. Implementations call this from an init() function.
+func RegisterOutput(name string, factory func() Output) {
+	outputFactoriesMu.Lock()
+	defer outputFactoriesMu.Unlock()
+	outputFactories[name] = factory
+}
+
+// stringSliceSetting reads a []string-shaped setting out of a config
+// "settings" map, where YAML unmarshaling leaves list values as []interface{}.
+func stringSliceSetting(settings map[string]interface{}, key string) ([]string, error) {
+	raw, ok := settings[key].([]interface{})
+	if !ok || len(raw) == 0 {
+		return nil, fmt.Errorf("%q setting is required and must be a non-empty list", key)
+	}
+	out := make([]string, 0, len(raw))
+	for _, v := range raw {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%q setting must be a list of strings", key)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// newOutput instantiates a registered Output by name.
+func newOutput(name string) (Output, error) {
+	outputFactoriesMu.Lock()
+	factory, ok := outputFactories[name]
+	outputFactoriesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown output type %q", name)
+	}
+	return factory(), nil
+}
+
+// sinkRunner wraps a configured Output with its own buffer, batch size and
+// flush ticker so that sinks flush independently of one another.
+type sinkRunner struct {
+	name    string
+	output  Output
+	manager *OutputManager
+
+	mu      sync.Mutex
+	pending []AISRecord
+
+	// writeMu serializes flushNow, which run, FlushAll and Close all call,
+	// so output.Write never runs concurrently with itself.
+	writeMu sync.Mutex
+
+	batchSize     int
+	flushInterval time.Duration
+
+	flush chan struct{}
+	done  chan struct{}
+}
+
+// OutputManager fans buffered AIS records out to every configured sink.
+// It is the single point handleAISMessage and replayWAL push records
+// through once an output is enabled in the config.
+type OutputManager struct {
+	sinks []*sinkRunner
+
+	confirmedMu sync.Mutex
+	confirmed   map[string]uint64 // per-sink highest WAL seq durably flushed so far
+
+	// OnCheckpoint, when set, is called with the new cross-sink floor
+	// whenever every configured sink's confirmed seq advances. This makes
+	// WAL durability independent of any one sink (e.g. Parquet): a
+	// kafka-only or mqtt-only config still checkpoints, gated on whichever
+	// configured sink is currently the slowest to flush. See wal.go.
+	OnCheckpoint func(upToSeq uint64)
+}
+
+// NewOutputManager builds sink runners for every enabled entry in cfgs and
+// initializes each Output. Outputs that fail to initialize are logged and
+// skipped so that one misconfigured sink cannot prevent the others from
+// running.
+func NewOutputManager(cfgs []OutputConfig) *OutputManager {
+	m := &OutputManager{}
+	for _, cfg := range cfgs {
+		if !cfg.Enabled {
+			continue
+		}
+
+		out, err := newOutput(cfg.Type)
+		if err != nil {
+			log.Printf("output %q: %v, skipping", cfg.Name, err)
+			continue
+		}
+
+		if err := out.Init(cfg.Settings); err != nil {
+			log.Printf("output %q: init failed: %v, skipping", cfg.Name, err)
+			continue
+		}
+
+		batchSize := cfg.BatchSize
+		if batchSize <= 0 {
+			batchSize = 1000
+		}
+		flushInterval, err := cfg.Duration()
+		if err != nil {
+			log.Printf("output %q: %v, skipping", cfg.Name, err)
+			continue
+		}
+		if flushInterval <= 0 {
+			flushInterval = FLUSH_INTERVAL
+		}
+
+		runner := &sinkRunner{
+			name:          cfg.Name,
+			output:        out,
+			manager:       m,
+			batchSize:     batchSize,
+			flushInterval: flushInterval,
+			flush:         make(chan struct{}, 1),
+			done:          make(chan struct{}),
+		}
+		m.sinks = append(m.sinks, runner)
+		go runner.run()
+
+		log.Printf("Output %q (%s) enabled: batchSize=%d flushInterval=%s", cfg.Name, cfg.Type, batchSize, flushInterval)
+	}
+	return m
+}
+
+// Write enqueues rec on every configured sink. Each sink buffers and flushes
+// independently, so a slow or failing sink never blocks the others.
+func (m *OutputManager) Write(rec AISRecord) {
+	for _, s := range m.sinks {
+		s.enqueue(rec)
+	}
+}
+
+// FlushAll forces every sink to write out whatever is currently pending,
+// without waiting for its next tick. Used after WAL replay so recovered
+// records reach disk before the collector starts accepting new traffic.
+func (m *OutputManager) FlushAll() {
+	for _, s := range m.sinks {
+		s.flushNow()
+	}
+}
+
+// Close flushes and closes every sink, waiting for each to drain.
+func (m *OutputManager) Close() {
+	for _, s := range m.sinks {
+		close(s.done)
+	}
+	for _, s := range m.sinks {
+		s.flushNow()
+		if err := s.output.Close(); err != nil {
+			log.Printf("output %q: close error: %v", s.name, err)
+		}
+	}
+}
+
+func (s *sinkRunner) enqueue(rec AISRecord) {
+	s.mu.Lock()
+	s.pending = append(s.pending, rec)
+	full := len(s.pending) >= s.batchSize
+	s.reportBufferLocked()
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run drives the sink's flush cadence until Close signals done.
+func (s *sinkRunner) run() {
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.flushNow()
+		case <-s.flush:
+			s.flushNow()
+		}
+	}
+}
+
+// flushNow writes whatever is pending to the underlying Output. Errors are
+// logged rather than propagated so one bad batch doesn't take the sink down.
+func (s *sinkRunner) flushNow() {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if len(s.pending) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	batch := s.pending
+	s.pending = nil
+	s.reportBufferLocked()
+	s.mu.Unlock()
+
+	if err := s.output.Write(batch); err != nil {
+		log.Printf("output %q: write failed for %d records: %v", s.name, len(batch), err)
+		return
+	}
+
+	var maxSeq uint64
+	for _, rec := range batch {
+		if rec.walSeq > maxSeq {
+			maxSeq = rec.walSeq
+		}
+	}
+	if maxSeq > 0 && s.manager != nil {
+		s.manager.reportFlushed(s.name, maxSeq)
+	}
+}
+
+// reportFlushed records that the named sink has durably flushed everything
+// up to seq, and advances OnCheckpoint's argument to the lowest confirmed
+// seq across every configured sink. A sink that hasn't flushed yet holds
+// the floor at 0, so the WAL isn't checkpointed until every enabled sink
+// has confirmed at least once.
+func (m *OutputManager) reportFlushed(name string, seq uint64) {
+	if m.OnCheckpoint == nil {
+		return
+	}
+
+	m.confirmedMu.Lock()
+	if m.confirmed == nil {
+		m.confirmed = make(map[string]uint64)
+	}
+	if seq > m.confirmed[name] {
+		m.confirmed[name] = seq
+	}
+
+	floor := m.confirmed[m.sinks[0].name]
+	for _, s := range m.sinks[1:] {
+		if c := m.confirmed[s.name]; c < floor {
+			floor = c
+		}
+	}
+	m.confirmedMu.Unlock()
+
+	if floor > 0 {
+		m.OnCheckpoint(floor)
+	}
+}
+
+// reportBufferLocked updates the ais_buffer_records/ais_buffer_fill_ratio
+// gauges for this sink from its current pending length. Callers must hold
+// s.mu.
+func (s *sinkRunner) reportBufferLocked() {
+	bufferRecords.WithLabelValues(s.name).Set(float64(len(s.pending)))
+	bufferFillRatio.WithLabelValues(s.name).Set(float64(len(s.pending)) / float64(s.batchSize))
+}
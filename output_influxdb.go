@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterOutput("influxdb", func() Output { return &InfluxDBOutput{} })
+}
+
+// InfluxDBOutput writes AIS records as InfluxDB line protocol over HTTP,
+// using the /write endpoint of the v1-compatible API.
+type InfluxDBOutput struct {
+	writeURL string
+	client   *http.Client
+}
+
+// Init builds the write URL from "url" (e.g. "http://localhost:8086"),
+// "database" and optional "precision" (defaults to "ns") settings.
+func (o *InfluxDBOutput) Init(settings map[string]interface{}) error {
+	baseURL, ok := settings["url"].(string)
+	if !ok || baseURL == "" {
+		return fmt.Errorf("influxdb output: \"url\" setting is required")
+	}
+	database, ok := settings["database"].(string)
+	if !ok || database == "" {
+		return fmt.Errorf("influxdb output: \"database\" setting is required")
+	}
+	precision := "ns"
+	if v, ok := settings["precision"].(string); ok && v != "" {
+		precision = v
+	}
+
+	o.writeURL = fmt.Sprintf("%s/write?db=%s&precision=%s", strings.TrimRight(baseURL, "/"), database, precision)
+	o.client = &http.Client{Timeout: 10 * time.Second}
+	return nil
+}
+
+// Write encodes batch as line protocol, one "ais_record" measurement per
+// record tagged by mmsi and message_type, and POSTs it in a single request.
+// Only the fields populated for the record's MessageType are included.
+func (o *InfluxDBOutput) Write(batch []AISRecord) error {
+	var buf bytes.Buffer
+	for _, rec := range batch {
+		ts, err := time.Parse(time.RFC3339, rec.Timestamp)
+		if err != nil {
+			ts = time.Now().UTC()
+		}
+
+		fields := lineProtocolFields(rec)
+		if fields == "" {
+			continue
+		}
+
+		buf.WriteString("ais_record,mmsi=")
+		buf.WriteString(rec.MMSI)
+		buf.WriteString(",message_type=")
+		buf.WriteString(rec.MessageType)
+		buf.WriteByte(' ')
+		buf.WriteString(fields)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(ts.UnixNano(), 10))
+		buf.WriteByte('\n')
+	}
+
+	resp, err := o.client.Post(o.writeURL, "text/plain; charset=utf-8", &buf)
+	if err != nil {
+		return fmt.Errorf("influxdb output: write request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("influxdb output: write failed with status %s", resp.Status)
+	}
+	return nil
+}
+
+// Close is a no-op: writes are stateless HTTP requests.
+func (o *InfluxDBOutput) Close() error {
+	return nil
+}
+
+// lineProtocolFields builds the space-free field-set portion of an InfluxDB
+// line for rec, skipping any column left nil for this message type.
+func lineProtocolFields(rec AISRecord) string {
+	var fields []string
+	if rec.Lat != nil {
+		fields = append(fields, fmt.Sprintf("lat=%f", *rec.Lat))
+	}
+	if rec.Lon != nil {
+		fields = append(fields, fmt.Sprintf("lon=%f", *rec.Lon))
+	}
+	if rec.Sog != nil {
+		fields = append(fields, fmt.Sprintf("sog=%f", *rec.Sog))
+	}
+	if rec.Cog != nil {
+		fields = append(fields, fmt.Sprintf("cog=%f", *rec.Cog))
+	}
+	if rec.TrueHeading != nil {
+		fields = append(fields, fmt.Sprintf("true_heading=%di", *rec.TrueHeading))
+	}
+	if rec.NavStatus != nil {
+		fields = append(fields, fmt.Sprintf("nav_status=%di", *rec.NavStatus))
+	}
+	if rec.Draught != nil {
+		fields = append(fields, fmt.Sprintf("draught=%f", *rec.Draught))
+	}
+	if rec.ImoNumber != nil {
+		fields = append(fields, fmt.Sprintf("imo_number=%di", *rec.ImoNumber))
+	}
+	if rec.ShipType != nil {
+		fields = append(fields, fmt.Sprintf("ship_type=%di", *rec.ShipType))
+	}
+	if rec.ShipName != nil {
+		fields = append(fields, fmt.Sprintf("ship_name=%s", strconv.Quote(*rec.ShipName)))
+	}
+	if rec.Destination != nil {
+		fields = append(fields, fmt.Sprintf("destination=%s", strconv.Quote(*rec.Destination)))
+	}
+	if rec.CallSign != nil {
+		fields = append(fields, fmt.Sprintf("call_sign=%s", strconv.Quote(*rec.CallSign)))
+	}
+	if rec.RawJSON != nil {
+		fields = append(fields, fmt.Sprintf("raw_json=%s", strconv.Quote(*rec.RawJSON)))
+	}
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+func init() {
+	RegisterOutput("kafka", func() Output { return &KafkaOutput{} })
+}
+
+// KafkaOutput publishes each AISRecord as a JSON message keyed by MMSI to a
+// Kafka topic, so downstream analytics pipelines can consume the live feed
+// without going through the Parquet archive.
+type KafkaOutput struct {
+	writer *kafka.Writer
+}
+
+// Init reads "brokers" ([]interface{} of host:port strings) and "topic" from
+// settings.
+func (o *KafkaOutput) Init(settings map[string]interface{}) error {
+	brokers, err := stringSliceSetting(settings, "brokers")
+	if err != nil {
+		return err
+	}
+	topic, ok := settings["topic"].(string)
+	if !ok || topic == "" {
+		return fmt.Errorf("kafka output: \"topic\" setting is required")
+	}
+
+	o.writer = &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: 500 * time.Millisecond,
+		RequiredAcks: kafka.RequireOne,
+	}
+	return nil
+}
+
+// Write publishes batch to the configured topic.
+func (o *KafkaOutput) Write(batch []AISRecord) error {
+	messages := make([]kafka.Message, 0, len(batch))
+	for _, rec := range batch {
+		value, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("kafka output: marshal record: %w", err)
+		}
+		messages = append(messages, kafka.Message{
+			Key:   []byte(rec.MMSI),
+			Value: value,
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return o.writer.WriteMessages(ctx, messages...)
+}
+
+// Close flushes and closes the underlying Kafka writer.
+func (o *KafkaOutput) Close() error {
+	return o.writer.Close()
+}
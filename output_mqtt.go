@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func init() {
+	RegisterOutput("mqtt", func() Output { return &MQTTOutput{} })
+}
+
+// MQTTOutput publishes each AISRecord as a JSON payload to an MQTT broker
+// under a per-MMSI topic, for lightweight fan-out to IoT-style subscribers.
+type MQTTOutput struct {
+	client      mqtt.Client
+	topicPrefix string
+	qos         byte
+}
+
+// Init connects to the broker given by the "broker" setting (e.g.
+// "tcp://localhost:1883"). "topic_prefix" defaults to "ais" and records are
+// published to "<topic_prefix>/<mmsi>". "qos" defaults to 0.
+func (o *MQTTOutput) Init(settings map[string]interface{}) error {
+	broker, ok := settings["broker"].(string)
+	if !ok || broker == "" {
+		return fmt.Errorf("mqtt output: \"broker\" setting is required")
+	}
+
+	o.topicPrefix = "ais"
+	if v, ok := settings["topic_prefix"].(string); ok && v != "" {
+		o.topicPrefix = v
+	}
+
+	o.qos = 0
+	if v, ok := settings["qos"].(int); ok {
+		o.qos = byte(v)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("ais-service-%d", time.Now().UnixNano())).
+		SetConnectRetry(true)
+
+	o.client = mqtt.NewClient(opts)
+	token := o.client.Connect()
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("mqtt output: connect to %s: %w", broker, token.Error())
+	}
+	return nil
+}
+
+// Write publishes each record in batch individually so subscribers can
+// filter by MMSI-scoped topic.
+func (o *MQTTOutput) Write(batch []AISRecord) error {
+	for _, rec := range batch {
+		payload, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("mqtt output: marshal record: %w", err)
+		}
+
+		topic := fmt.Sprintf("%s/%s", o.topicPrefix, rec.MMSI)
+		token := o.client.Publish(topic, o.qos, false, payload)
+		if token.Wait() && token.Error() != nil {
+			return fmt.Errorf("mqtt output: publish to %s: %w", topic, token.Error())
+		}
+	}
+	return nil
+}
+
+// Close disconnects from the broker, waiting up to 250ms to drain in-flight
+// publishes.
+func (o *MQTTOutput) Close() error {
+	o.client.Disconnect(250)
+	return nil
+}
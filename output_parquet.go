@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+func init() {
+	RegisterOutput("parquet", func() Output { return &ParquetOutput{} })
+}
+
+// ParquetOutput is the original local sink: every batch is written as its
+// own compressed Parquet file under dir, matching the historical
+// ais_data/YYYY-MM-DD_HH-MM-SS.parquet layout.
+type ParquetOutput struct {
+	dir string
+}
+
+// Init reads the "dir" setting, defaulting to "ais_data" for parity with the
+// service's pre-Output-interface behavior.
+func (o *ParquetOutput) Init(settings map[string]interface{}) error {
+	o.dir = "ais_data"
+	if v, ok := settings["dir"].(string); ok && v != "" {
+		o.dir = v
+	}
+	return os.MkdirAll(o.dir, os.ModePerm)
+}
+
+// Write serializes batch into a new timestamped Parquet file.
+func (o *ParquetOutput) Write(batch []AISRecord) error {
+	currentFile := filepath.Join(o.dir, fmt.Sprintf("%s.parquet", time.Now().Format("2006-01-02_15-04-05")))
+
+	fw, err := local.NewLocalFileWriter(currentFile)
+	if err != nil {
+		return fmt.Errorf("creating parquet file writer: %w", err)
+	}
+
+	pw, err := writer.NewParquetWriter(fw, new(AISRecord), 4)
+	if err != nil {
+		fw.Close()
+		return fmt.Errorf("initializing parquet writer: %w", err)
+	}
+	pw.CompressionType = parquet.CompressionCodec_ZSTD
+
+	startTime := time.Now()
+	for _, rec := range batch {
+		if err := pw.Write(rec); err != nil {
+			log.Println("Parquet write error:", err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		return fmt.Errorf("finalizing parquet file %s: %w", currentFile, err)
+	}
+	fw.Close()
+	parquetFlushDuration.Observe(time.Since(startTime).Seconds())
+
+	log.Printf("Parquet file %s written successfully in %.2f seconds (%d records).", currentFile, time.Since(startTime).Seconds(), len(batch))
+
+	if fileInfo, err := os.Stat(currentFile); err == nil {
+		parquetFileBytes.Observe(float64(fileInfo.Size()))
+	}
+
+	return nil
+}
+
+// Close is a no-op: every Write is already a self-contained, closed file.
+func (o *ParquetOutput) Close() error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+func init() {
+	RegisterOutput("stdout", func() Output { return &StdoutOutput{} })
+}
+
+// StdoutOutput writes each AISRecord as a single line of JSON to stdout.
+// It's primarily useful for local debugging and for piping the live feed
+// into another process (e.g. `jq`) without standing up a real sink.
+type StdoutOutput struct {
+	w *bufio.Writer
+}
+
+// Init sets up a buffered writer over os.Stdout. No settings are required.
+func (o *StdoutOutput) Init(settings map[string]interface{}) error {
+	o.w = bufio.NewWriter(os.Stdout)
+	return nil
+}
+
+// Write prints one JSON object per record, newline-delimited.
+func (o *StdoutOutput) Write(batch []AISRecord) error {
+	enc := json.NewEncoder(o.w)
+	for _, rec := range batch {
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("stdout output: encode record: %w", err)
+		}
+	}
+	return o.w.Flush()
+}
+
+// Close flushes any buffered output.
+func (o *StdoutOutput) Close() error {
+	return o.w.Flush()
+}
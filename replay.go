@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// replayStatus is the shared, mutex-guarded state behind /replay/status.
+// It's nil unless the service was started with --replay.
+var (
+	replayStatusMu sync.Mutex
+	replayStatus   *ReplayStatus
+)
+
+// ReplayStatus reports the progress of an in-flight replay run.
+type ReplayStatus struct {
+	VirtualClock    string  `json:"virtual_clock"`
+	RecordsTotal    int     `json:"records_total"`
+	RecordsReplayed int     `json:"records_replayed"`
+	Speed           float64 `json:"speed"`
+	Done            bool    `json:"done"`
+	ETA             string  `json:"eta,omitempty"`
+}
+
+// runReplayer re-emits previously archived Parquet files through the normal
+// collector pipeline, at wall-clock pace scaled by speed (1.0 = real-time,
+// 0 = as fast as possible). It never touches the live WebSocket source, so
+// it can safely run against a fresh set of Output sinks for re-encoding,
+// redistribution, or deterministic integration tests.
+func runReplayer(files []string, speed float64, ctx context.Context, db *sql.DB) {
+	sort.Strings(files) // filenames are YYYY-MM-DD_HH-MM-SS.parquet, so lexical order is chronological
+
+	log.Printf("Starting AIS replay over %d file(s) at speed=%.2f", len(files), speed)
+
+	rows, err := loadReplayRows(files, db)
+	if err != nil {
+		log.Fatal("Replay: failed to load rows:", err)
+	}
+
+	replayStatusMu.Lock()
+	replayStatus = &ReplayStatus{RecordsTotal: len(rows), Speed: speed}
+	replayStatusMu.Unlock()
+
+	var lastTimestamp time.Time
+	start := time.Now()
+
+	for i, row := range rows {
+		select {
+		case <-ctx.Done():
+			log.Println("Replay interrupted by shutdown")
+			return
+		default:
+		}
+
+		ts, err := time.Parse(time.RFC3339, row.Timestamp)
+		if err != nil {
+			ts = time.Now().UTC()
+		}
+
+		if speed > 0 && !lastTimestamp.IsZero() {
+			if delta := ts.Sub(lastTimestamp); delta > 0 {
+				time.Sleep(time.Duration(float64(delta) / speed))
+			}
+		}
+		lastTimestamp = ts
+
+		raw, err := row.rawJSON()
+		if err != nil {
+			log.Println("Replay: skipping record, could not reconstruct JSON:", err)
+			continue
+		}
+		handleAISMessageAt(raw, db, row.Timestamp)
+
+		updateReplayStatus(i+1, len(rows), ts, start, speed)
+	}
+
+	outputs.FlushAll()
+
+	replayStatusMu.Lock()
+	if replayStatus != nil {
+		replayStatus.Done = true
+	}
+	replayStatusMu.Unlock()
+
+	log.Println("Replay complete.")
+}
+
+// updateReplayStatus refreshes the shared status, estimating remaining time
+// from the average pace achieved so far.
+func updateReplayStatus(processed, total int, virtualClock time.Time, start time.Time, speed float64) {
+	replayStatusMu.Lock()
+	defer replayStatusMu.Unlock()
+	if replayStatus == nil {
+		return
+	}
+	replayStatus.RecordsReplayed = processed
+	replayStatus.VirtualClock = virtualClock.Format(time.RFC3339)
+
+	if processed > 0 && processed < total {
+		perRecord := time.Since(start) / time.Duration(processed)
+		remaining := perRecord * time.Duration(total-processed)
+		replayStatus.ETA = time.Now().Add(remaining).Format(time.RFC3339)
+	}
+}
+
+// getReplayStatus handles GET /replay/status, returning 404 before any
+// replay has been started.
+func getReplayStatus(c *gin.Context) {
+	replayStatusMu.Lock()
+	defer replayStatusMu.Unlock()
+
+	if replayStatus == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no replay in progress"})
+		return
+	}
+	c.JSON(http.StatusOK, replayStatus)
+}
+
+// replayRow is one decoded Parquet row, kept generic enough to either reuse
+// its original raw_json (if the file was captured with include_raw_json set)
+// or reconstruct an equivalent aisstream.io-shaped payload from the typed
+// columns.
+type replayRow struct {
+	Timestamp   string
+	MMSI        string
+	MessageType string
+	Lat, Lon    sql.NullFloat64
+	Sog, Cog    sql.NullFloat64
+	TrueHeading sql.NullInt64
+	NavStatus   sql.NullInt64
+	ShipName    sql.NullString
+	Destination sql.NullString
+	Draught     sql.NullFloat64
+	ImoNumber   sql.NullInt64
+	CallSign    sql.NullString
+	ShipType    sql.NullInt64
+	RawJSON     sql.NullString
+}
+
+// rawJSON returns the bytes that would have arrived over the WebSocket for
+// this row: the original payload if it was archived, otherwise a
+// reconstruction built from the typed columns.
+func (r replayRow) rawJSON() ([]byte, error) {
+	if r.RawJSON.Valid {
+		return []byte(r.RawJSON.String), nil
+	}
+
+	userID, err := strconv.ParseFloat(r.MMSI, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing mmsi %q: %w", r.MMSI, err)
+	}
+
+	sub := map[string]interface{}{"UserID": userID}
+	switch r.MessageType {
+	case "PositionReport":
+		setIfValid(sub, "Latitude", r.Lat)
+		setIfValid(sub, "Longitude", r.Lon)
+		setIfValid(sub, "Sog", r.Sog)
+		setIfValid(sub, "Cog", r.Cog)
+		setIfValid(sub, "TrueHeading", r.TrueHeading)
+		setIfValid(sub, "NavigationalStatus", r.NavStatus)
+	case "StandardClassBPositionReport":
+		setIfValid(sub, "Latitude", r.Lat)
+		setIfValid(sub, "Longitude", r.Lon)
+		setIfValid(sub, "Sog", r.Sog)
+		setIfValid(sub, "Cog", r.Cog)
+		setIfValid(sub, "TrueHeading", r.TrueHeading)
+	case "AidsToNavigationReport":
+		setIfValid(sub, "Latitude", r.Lat)
+		setIfValid(sub, "Longitude", r.Lon)
+		setIfValid(sub, "Name", r.ShipName)
+	case "ShipStaticData":
+		setIfValid(sub, "Name", r.ShipName)
+		setIfValid(sub, "Destination", r.Destination)
+		setIfValid(sub, "CallSign", r.CallSign)
+		setIfValid(sub, "MaximumStaticDraught", r.Draught)
+		setIfValid(sub, "ImoNumber", r.ImoNumber)
+		setIfValid(sub, "ShipType", r.ShipType)
+	default:
+		return nil, fmt.Errorf("unknown message type %q", r.MessageType)
+	}
+
+	payload := map[string]interface{}{
+		"MessageType": r.MessageType,
+		"Message":     map[string]interface{}{r.MessageType: sub},
+	}
+	return json.Marshal(payload)
+}
+
+// toAISRecord converts the row's sql.Null* columns back into AISRecord's
+// nullable pointer fields, the inverse of however they were originally
+// written. Used by the compactor, which re-serializes archived rows rather
+// than re-deriving them from raw JSON.
+func (r replayRow) toAISRecord() AISRecord {
+	rec := AISRecord{Timestamp: r.Timestamp, MMSI: r.MMSI, MessageType: r.MessageType}
+	if r.Lat.Valid {
+		v := r.Lat.Float64
+		rec.Lat = &v
+	}
+	if r.Lon.Valid {
+		v := r.Lon.Float64
+		rec.Lon = &v
+	}
+	if r.Sog.Valid {
+		v := r.Sog.Float64
+		rec.Sog = &v
+	}
+	if r.Cog.Valid {
+		v := r.Cog.Float64
+		rec.Cog = &v
+	}
+	if r.TrueHeading.Valid {
+		v := int32(r.TrueHeading.Int64)
+		rec.TrueHeading = &v
+	}
+	if r.NavStatus.Valid {
+		v := int32(r.NavStatus.Int64)
+		rec.NavStatus = &v
+	}
+	if r.ShipName.Valid {
+		v := r.ShipName.String
+		rec.ShipName = &v
+	}
+	if r.Destination.Valid {
+		v := r.Destination.String
+		rec.Destination = &v
+	}
+	if r.Draught.Valid {
+		v := r.Draught.Float64
+		rec.Draught = &v
+	}
+	if r.ImoNumber.Valid {
+		v := int32(r.ImoNumber.Int64)
+		rec.ImoNumber = &v
+	}
+	if r.CallSign.Valid {
+		v := r.CallSign.String
+		rec.CallSign = &v
+	}
+	if r.ShipType.Valid {
+		v := int32(r.ShipType.Int64)
+		rec.ShipType = &v
+	}
+	if r.RawJSON.Valid {
+		v := r.RawJSON.String
+		rec.RawJSON = &v
+	}
+	return rec
+}
+
+// setIfValid assigns v's underlying value into m[key] only if v is a valid
+// (non-NULL) sql.Null* value.
+func setIfValid(m map[string]interface{}, key string, v interface{}) {
+	switch val := v.(type) {
+	case sql.NullFloat64:
+		if val.Valid {
+			m[key] = val.Float64
+		}
+	case sql.NullInt64:
+		if val.Valid {
+			m[key] = val.Int64
+		}
+	case sql.NullString:
+		if val.Valid {
+			m[key] = val.String
+		}
+	}
+}
+
+// loadReplayRows queries every file for the columns needed to replay it, in
+// timestamp order, across the whole file set.
+func loadReplayRows(files []string, db *sql.DB) ([]replayRow, error) {
+	quoted := make([]string, len(files))
+	for i, f := range files {
+		quoted[i] = "'" + filepath.ToSlash(f) + "'"
+	}
+	fileList := "ARRAY[" + strings.Join(quoted, ", ") + "]"
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM read_parquet(%s, union_by_name := true)
+		ORDER BY timestamp ASC`, aisRecordColumns, fileList)
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []replayRow
+	for rows.Next() {
+		var r replayRow
+		if err := rows.Scan(&r.Timestamp, &r.MMSI, &r.MessageType, &r.Lat, &r.Lon, &r.Sog, &r.Cog,
+			&r.TrueHeading, &r.NavStatus, &r.ShipName, &r.Destination, &r.Draught, &r.ImoNumber,
+			&r.CallSign, &r.ShipType, &r.RawJSON); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// streamDroppedTotal counts records dropped from a slow subscriber's buffer
+// because it couldn't keep up with the live feed (drop-oldest policy).
+var streamDroppedTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "ais_stream_dropped_total",
+	Help: "Total number of records dropped from a /stream subscriber's buffer because it fell behind.",
+})
+
+// subscriberBuffer is the per-subscriber channel depth. Once full, the
+// oldest buffered record is dropped to make room for the newest.
+const subscriberBuffer = 256
+
+// streamFilter narrows the live feed a subscriber receives. Zero-value
+// fields mean "no filter" on that dimension.
+type streamFilter struct {
+	mmsi        map[string]bool
+	messageType string
+	bbox        *boundingBoxFilter
+	minInterval time.Duration // derived from sampleHz; zero means unthrottled
+}
+
+type boundingBoxFilter struct {
+	minLat, minLon, maxLat, maxLon float64
+}
+
+// matches reports whether rec passes every dimension configured on f.
+func (f streamFilter) matches(rec AISRecord) bool {
+	if len(f.mmsi) > 0 && !f.mmsi[rec.MMSI] {
+		return false
+	}
+	if f.messageType != "" && f.messageType != rec.MessageType {
+		return false
+	}
+	if f.bbox != nil {
+		if rec.Lat == nil || rec.Lon == nil {
+			return false
+		}
+		if *rec.Lat < f.bbox.minLat || *rec.Lat > f.bbox.maxLat || *rec.Lon < f.bbox.minLon || *rec.Lon > f.bbox.maxLon {
+			return false
+		}
+	}
+	return true
+}
+
+// parseStreamFilter reads the mmsi/bbox/messageType/sampleHz query params
+// shared by /stream/ws and /stream/sse. On bad input it returns a
+// user-facing message and ok=false.
+func parseStreamFilter(c *gin.Context) (f streamFilter, errMsg string, ok bool) {
+	if v := c.Query("mmsi"); v != "" {
+		f.mmsi = make(map[string]bool)
+		for _, m := range strings.Split(v, ",") {
+			f.mmsi[strings.TrimSpace(m)] = true
+		}
+	}
+
+	f.messageType = c.Query("messageType")
+
+	if v := c.Query("bbox"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 {
+			return f, "bbox must be minLat,minLon,maxLat,maxLon", false
+		}
+		vals := make([]float64, 4)
+		for i, p := range parts {
+			n, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+			if err != nil {
+				return f, "bbox must be minLat,minLon,maxLat,maxLon", false
+			}
+			vals[i] = n
+		}
+		f.bbox = &boundingBoxFilter{minLat: vals[0], minLon: vals[1], maxLat: vals[2], maxLon: vals[3]}
+	}
+
+	if v := c.Query("sampleHz"); v != "" {
+		hz, err := strconv.ParseFloat(v, 64)
+		if err != nil || hz <= 0 {
+			return f, "sampleHz must be a positive number", false
+		}
+		f.minInterval = time.Duration(float64(time.Second) / hz)
+	}
+
+	return f, "", true
+}
+
+// subscriber is one live consumer of the Hub, holding its own bounded
+// buffer and filter.
+type subscriber struct {
+	id       uint64
+	ch       chan AISRecord
+	filter   streamFilter
+	lastSent time.Time
+}
+
+// Hub fans every buffered AISRecord out to every matching subscriber. It's
+// invoked from handleAISMessage after a record has been dispatched to the
+// configured Output sinks, so /stream consumers see data as it arrives
+// rather than waiting for the next Parquet flush.
+type Hub struct {
+	mu     sync.RWMutex
+	subs   map[uint64]*subscriber
+	nextID uint64
+}
+
+var streamHub = &Hub{subs: make(map[uint64]*subscriber)}
+
+// Subscribe registers a new subscriber matching filter and returns its
+// receive channel and an unsubscribe function.
+func (h *Hub) Subscribe(filter streamFilter) (<-chan AISRecord, func()) {
+	h.mu.Lock()
+	h.nextID++
+	id := h.nextID
+	sub := &subscriber{id: id, ch: make(chan AISRecord, subscriberBuffer), filter: filter}
+	h.subs[id] = sub
+	h.mu.Unlock()
+
+	return sub.ch, func() {
+		h.mu.Lock()
+		delete(h.subs, id)
+		h.mu.Unlock()
+		close(sub.ch)
+	}
+}
+
+// Publish delivers rec to every subscriber whose filter matches. A
+// subscriber that isn't keeping up has its oldest buffered record dropped
+// to make room, rather than blocking the collector.
+func (h *Hub) Publish(rec AISRecord) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	now := time.Now()
+	for _, sub := range h.subs {
+		if !sub.filter.matches(rec) {
+			continue
+		}
+		if sub.filter.minInterval > 0 && now.Sub(sub.lastSent) < sub.filter.minInterval {
+			continue
+		}
+		sub.lastSent = now
+
+		select {
+		case sub.ch <- rec:
+		default:
+			// Slow consumer: drop the oldest buffered record and retry once.
+			select {
+			case <-sub.ch:
+				streamDroppedTotal.Inc()
+			default:
+			}
+			select {
+			case sub.ch <- rec:
+			default:
+			}
+		}
+	}
+}
+
+// streamWebSocketUpgrader mirrors the collector's use of gorilla/websocket,
+// accepting connections from any origin since this is an internal
+// redistribution endpoint, not a browser-facing one.
+var streamWebSocketUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamWS handles GET /stream/ws, upgrading to a WebSocket and pushing
+// every matching AISRecord as a JSON text frame until the client
+// disconnects.
+func streamWS(c *gin.Context) {
+	filter, errMsg, ok := parseStreamFilter(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	conn, err := streamWebSocketUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	records, unsubscribe := streamHub.Subscribe(filter)
+	defer unsubscribe()
+
+	// The client never sends anything after the handshake, but the
+	// connection still needs a reader: it's the only way to learn a close
+	// frame (or a dropped TCP connection) arrived, since a subscriber on a
+	// low-traffic filter might otherwise not attempt another WriteJSON for
+	// a long time. Mirrors the ctx.Done() case streamSSE gets for free from
+	// gin's c.Stream.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case rec, ok := <-records:
+			if !ok {
+				return
+			}
+			if err := conn.WriteJSON(rec); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// streamSSE handles GET /stream/sse, pushing every matching AISRecord as a
+// Server-Sent Event until the client disconnects.
+func streamSSE(c *gin.Context) {
+	filter, errMsg, ok := parseStreamFilter(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		return
+	}
+
+	records, unsubscribe := streamHub.Subscribe(filter)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case rec, ok := <-records:
+			if !ok {
+				return false
+			}
+			c.SSEvent("ais_record", rec)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
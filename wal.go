@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// walDir is where WAL segments are written, one per UTC hour:
+// ais_data/wal/YYYY-MM-DD_HH.log.
+const walDir = "ais_data/wal"
+
+// walCheckpointPath holds the highest WAL sequence number confirmed flushed
+// by every configured Output sink as of the last checkpoint; see Checkpoint.
+var walCheckpointPath = filepath.Join(walDir, "CHECKPOINT")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WAL is a per-record, append-only write-ahead log sitting in front of the
+// Output sinks. It exists to bound data loss on crash to whatever hasn't
+// been fsync'd, instead of up to MaxRecordsPerFile records or one
+// FLUSH_INTERVAL of traffic.
+//
+// Each record is framed as [8-byte seq][4-byte length][4-byte CRC32C][JSON
+// payload]. seq is a strictly increasing sequence number assigned to every
+// Append regardless of which segment it lands in, so Checkpoint/Replay can
+// tell exactly which records every sink has already flushed without
+// depending on segment (hour) boundaries lining up with flushes. A torn
+// write at the tail (the only kind a crash can produce, since writes are
+// append-only) is detectable and simply truncates replay at that point.
+type WAL struct {
+	mode string // "off", "async", or "sync"
+
+	mu       sync.Mutex
+	file     *os.File
+	hour     string
+	seq      uint64      // last sequence number assigned; guards against reuse across restarts
+	pending  chan []byte // used only in async mode
+	done     chan struct{}
+	closeErr error
+}
+
+// NewWAL opens (or creates) ais_data/wal and prepares it to accept Append
+// calls in the given mode. mode == "off" disables the WAL entirely, in
+// which case Append and Replay are no-ops.
+func NewWAL(mode string) (*WAL, error) {
+	if mode == "" {
+		mode = "off"
+	}
+	if mode != "off" && mode != "async" && mode != "sync" {
+		return nil, fmt.Errorf("invalid --wal mode %q (want off, async, or sync)", mode)
+	}
+
+	w := &WAL{mode: mode}
+	if mode == "off" {
+		return w, nil
+	}
+
+	if err := os.MkdirAll(walDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("creating WAL directory: %w", err)
+	}
+
+	// Resume sequence numbering from wherever a previous run left off, so a
+	// restart never reassigns a seq already present in an old, not-yet
+	// checkpointed segment.
+	seq, err := scanMaxSeq(walDir)
+	if err != nil {
+		return nil, fmt.Errorf("scanning existing WAL segments: %w", err)
+	}
+	// Checkpoint may already have removed every segment it covered, so the
+	// checkpoint marker is a floor too.
+	if confirmed, err := readCheckpoint(); err == nil && confirmed > seq {
+		seq = confirmed
+	}
+	w.seq = seq
+
+	if mode == "async" {
+		w.pending = make(chan []byte, 4096)
+		w.done = make(chan struct{})
+		go w.runAsync()
+	}
+	return w, nil
+}
+
+// Append synchronously frames rec (assigning it the next sequence number)
+// and, in sync mode, fsyncs it to the current hourly segment before
+// returning, so it is called before the record is handed to any Output. The
+// returned sequence number is later passed to Checkpoint once the record is
+// confirmed durable elsewhere (e.g. in Parquet).
+func (w *WAL) Append(rec AISRecord) (uint64, error) {
+	if w.mode == "off" {
+		return 0, nil
+	}
+
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return 0, fmt.Errorf("wal: marshal record: %w", err)
+	}
+
+	w.mu.Lock()
+	w.seq++
+	seq := w.seq
+	frame := encodeFrame(seq, payload)
+
+	if w.mode == "async" {
+		w.mu.Unlock()
+		select {
+		case w.pending <- frame:
+		default:
+			log.Println("wal: async write queue full, dropping frame")
+		}
+		return seq, nil
+	}
+
+	defer w.mu.Unlock()
+	if err := w.rotateIfNeededLocked(); err != nil {
+		return seq, err
+	}
+	if _, err := w.file.Write(frame); err != nil {
+		return seq, fmt.Errorf("wal: write frame: %w", err)
+	}
+	return seq, w.file.Sync()
+}
+
+// runAsync drains the pending queue and batches fsyncs, trading a small
+// durability window for throughput.
+func (w *WAL) runAsync() {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	dirty := false
+	for {
+		select {
+		case <-w.done:
+			w.flushAsync(&dirty)
+			return
+		case frame := <-w.pending:
+			w.mu.Lock()
+			if err := w.rotateIfNeededLocked(); err != nil {
+				log.Println("wal:", err)
+			} else if _, err := w.file.Write(frame); err != nil {
+				log.Println("wal: async write error:", err)
+			} else {
+				dirty = true
+			}
+			w.mu.Unlock()
+		case <-ticker.C:
+			w.flushAsync(&dirty)
+		}
+	}
+}
+
+func (w *WAL) flushAsync(dirty *bool) {
+	if !*dirty {
+		return
+	}
+	w.mu.Lock()
+	if w.file != nil {
+		if err := w.file.Sync(); err != nil {
+			log.Println("wal: async fsync error:", err)
+		}
+	}
+	w.mu.Unlock()
+	*dirty = false
+}
+
+// rotateIfNeededLocked opens a new hourly segment file when the wall-clock
+// hour has advanced. Callers must hold w.mu.
+func (w *WAL) rotateIfNeededLocked() error {
+	hour := time.Now().UTC().Format("2006-01-02_15")
+	if hour == w.hour && w.file != nil {
+		return nil
+	}
+
+	if w.file != nil {
+		w.file.Close()
+	}
+
+	path := filepath.Join(walDir, hour+".log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("wal: opening segment %s: %w", path, err)
+	}
+	w.file = f
+	w.hour = hour
+	return nil
+}
+
+// Close stops the async writer (if any) and closes the current segment.
+func (w *WAL) Close() error {
+	if w.mode == "off" {
+		return nil
+	}
+	if w.mode == "async" {
+		close(w.done)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		return w.file.Close()
+	}
+	return nil
+}
+
+// Checkpoint durably records that every record with sequence number <=
+// upToSeq is now confirmed flushed by every configured sink, then removes whatever past
+// hours' segments are fully covered by that sequence number.
+//
+// The active (current hour's) segment is never removed here even if every
+// frame in it so far is confirmed: it may still be receiving writes, and a
+// segment deleted out from under an in-flight Append/runAsync write would
+// lose whatever landed in it afterward. Its already-confirmed prefix is
+// instead skipped by sequence number on the next Replay, at the cost of
+// that file growing until the hour rolls over and it becomes eligible here.
+func (w *WAL) Checkpoint(upToSeq uint64) {
+	if w.mode == "off" || upToSeq == 0 {
+		return
+	}
+	if current, err := readCheckpoint(); err == nil && upToSeq <= current {
+		return
+	}
+	if err := writeCheckpoint(upToSeq); err != nil {
+		log.Println("wal: writing checkpoint marker:", err)
+		return
+	}
+
+	currentHour := time.Now().UTC().Format("2006-01-02_15")
+	entries, err := os.ReadDir(walDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) != ".log" {
+			continue
+		}
+		if strings.TrimSuffix(name, ".log") >= currentHour {
+			continue
+		}
+
+		path := filepath.Join(walDir, name)
+		maxSeq, err := maxSeqInSegment(path)
+		if err != nil || maxSeq > upToSeq {
+			continue // not fully confirmed yet (or unreadable); leave it for a later checkpoint
+		}
+		if err := os.Remove(path); err != nil {
+			log.Println("wal: checkpoint remove error:", err)
+		} else {
+			log.Printf("wal: removed checkpointed segment %s (seq <= %d)", name, upToSeq)
+		}
+	}
+}
+
+// Replay reconstructs every AISRecord not yet confirmed by the last
+// Checkpoint, across every WAL segment, in chronological (filename) order,
+// along with the highest sequence number among them. A framing/CRC error is
+// treated as a torn tail write and simply stops replay of that segment
+// rather than failing the whole recovery.
+//
+// Callers are expected to re-dispatch the returned records and then
+// Checkpoint(maxSeq) once they're durable again, or the same records will
+// be replayed once more on the next crash.
+func (w *WAL) Replay() ([]AISRecord, uint64, error) {
+	if w.mode == "off" {
+		return nil, 0, nil
+	}
+
+	confirmed, err := readCheckpoint()
+	if err != nil {
+		return nil, 0, fmt.Errorf("wal: reading checkpoint marker: %w", err)
+	}
+
+	entries, err := os.ReadDir(walDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("wal: listing segments: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".log" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var records []AISRecord
+	var maxSeq uint64
+	for _, name := range names {
+		recs, seq, err := replaySegment(filepath.Join(walDir, name), confirmed)
+		if err != nil {
+			log.Printf("wal: replaying %s: %v", name, err)
+		}
+		records = append(records, recs...)
+		if seq > maxSeq {
+			maxSeq = seq
+		}
+	}
+	return records, maxSeq, nil
+}
+
+// scanMaxSeq returns the highest sequence number found across every
+// existing segment in dir, so a restarted WAL keeps assigning strictly
+// increasing sequence numbers even when old, not-yet-checkpointed segments
+// are still around.
+func scanMaxSeq(dir string) (uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var maxSeq uint64
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".log" {
+			continue
+		}
+		seq, err := maxSeqInSegment(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if seq > maxSeq {
+			maxSeq = seq
+		}
+	}
+	return maxSeq, nil
+}
+
+// maxSeqInSegment returns the highest sequence number among the complete
+// frames in the segment at path, without decoding their JSON payloads.
+func maxSeqInSegment(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var maxSeq uint64
+	for {
+		seq, _, err := decodeFrame(f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return maxSeq, err
+		}
+		if seq > maxSeq {
+			maxSeq = seq
+		}
+	}
+	return maxSeq, nil
+}
+
+// replaySegment decodes every complete frame in a single WAL segment file,
+// skipping any with seq <= confirmed as already flushed by every sink, and
+// returns the highest seq seen (confirmed or not, so callers can tell how
+// far this segment has been read).
+func replaySegment(path string, confirmed uint64) ([]AISRecord, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var records []AISRecord
+	var maxSeq uint64
+	for {
+		seq, payload, err := decodeFrame(f)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return records, maxSeq, err
+		}
+		if seq > maxSeq {
+			maxSeq = seq
+		}
+		if seq <= confirmed {
+			continue
+		}
+
+		var rec AISRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return records, maxSeq, fmt.Errorf("decoding record: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return records, maxSeq, nil
+}
+
+// readCheckpoint returns the sequence number recorded by the last
+// Checkpoint, or 0 if none has run yet.
+func readCheckpoint() (uint64, error) {
+	data, err := os.ReadFile(walCheckpointPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	seq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing checkpoint marker: %w", err)
+	}
+	return seq, nil
+}
+
+// writeCheckpoint durably persists seq as the new checkpoint, via a
+// temp-file-then-rename so a crash mid-write never leaves a corrupt marker.
+func writeCheckpoint(seq uint64) error {
+	tmp := walCheckpointPath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(seq, 10)), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, walCheckpointPath); err != nil {
+		return err
+	}
+	return fsyncDir(walDir)
+}
+
+// encodeFrame builds a [seq][length][crc32c][payload] frame for a single
+// record.
+func encodeFrame(seq uint64, payload []byte) []byte {
+	frame := make([]byte, 16+len(payload))
+	binary.BigEndian.PutUint64(frame[0:8], seq)
+	binary.BigEndian.PutUint32(frame[8:12], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[12:16], crc32.Checksum(payload, crc32cTable))
+	copy(frame[16:], payload)
+	return frame
+}
+
+// decodeFrame reads and validates the next frame from r, returning io.EOF
+// once no further complete frame is available.
+func decodeFrame(r io.Reader) (uint64, []byte, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, err
+	}
+
+	seq := binary.BigEndian.Uint64(header[0:8])
+	length := binary.BigEndian.Uint32(header[8:12])
+	wantCRC := binary.BigEndian.Uint32(header[12:16])
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, io.EOF // torn tail write; stop replay here
+	}
+
+	if crc32.Checksum(payload, crc32cTable) != wantCRC {
+		return 0, nil, io.EOF // corrupt tail write; stop replay here
+	}
+	return seq, payload, nil
+}